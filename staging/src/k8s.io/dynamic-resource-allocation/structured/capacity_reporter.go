@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"context"
+
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CapacityStatus is the residual consumable capacity of a single
+// capacity dimension on a device, derived from calculateConsumedCapacity
+// and the aggregated ConsumedCapacityCollection.
+type CapacityStatus struct {
+	Total      resource.Quantity
+	Consumed   resource.Quantity
+	Free       resource.Quantity
+	PolicyKind string // "ValidRange", "ValidValues", or "" when not consumable.
+}
+
+// CapacityTransition describes a device moving between "has free share"
+// and "exhausted" for one capacity dimension.
+type CapacityTransition struct {
+	DeviceID  DeviceID
+	Name      resourceapi.QualifiedName
+	Exhausted bool
+}
+
+// CapacityReporter aggregates ConsumedCapacityCollection against the
+// declared capacity of each device and publishes a snapshot of residual
+// consumable capacity, mirroring the storage-capability controller's
+// pattern of watching a source of truth and writing a lightweight status
+// object for the scheduler and dashboards to read without recomputing.
+type CapacityReporter struct {
+	transitions chan CapacityTransition
+	writer      CapacityStatusWriter
+
+	// exhausted tracks which (device, capacity name) pairs were last
+	// reported as exhausted, so Refresh only emits a transition event
+	// when the state actually flips.
+	exhausted map[DeviceID]map[resourceapi.QualifiedName]bool
+}
+
+// CapacityStatusWriter upserts a CRD-shaped object summarizing residual
+// capacity so consumers don't have to recompute CapacityReporter.Snapshot
+// themselves. Implementations typically wrap a generated clientset.
+type CapacityStatusWriter interface {
+	Upsert(ctx context.Context, deviceID DeviceID, status map[resourceapi.QualifiedName]CapacityStatus) error
+}
+
+// NewCapacityReporter creates a CapacityReporter. writer may be nil if
+// only the in-process Snapshot/transition-event API is needed.
+func NewCapacityReporter(writer CapacityStatusWriter) *CapacityReporter {
+	return &CapacityReporter{
+		transitions: make(chan CapacityTransition, 64),
+		writer:      writer,
+		exhausted:   map[DeviceID]map[resourceapi.QualifiedName]bool{},
+	}
+}
+
+// Transitions returns the channel on which has-free-share/exhausted
+// transitions are published. Callers should drain it continuously;
+// Refresh drops an event rather than blocking if the channel is full.
+func (r *CapacityReporter) Transitions() <-chan CapacityTransition {
+	return r.transitions
+}
+
+// Snapshot computes the residual capacity of every consumable dimension
+// declared on deviceCapacity, given what has already been consumed.
+func (r *CapacityReporter) Snapshot(deviceCapacity map[DeviceID]map[resourceapi.QualifiedName]resourceapi.DeviceCapacity, consumed ConsumedCapacityCollection) map[DeviceID]map[resourceapi.QualifiedName]CapacityStatus {
+	snapshot := make(map[DeviceID]map[resourceapi.QualifiedName]CapacityStatus, len(deviceCapacity))
+	for deviceID, capacities := range deviceCapacity {
+		deviceStatus := make(map[resourceapi.QualifiedName]CapacityStatus, len(capacities))
+		for name, cap := range capacities {
+			deviceStatus[name] = capacityStatusFor(cap, consumed[deviceID][name])
+		}
+		snapshot[deviceID] = deviceStatus
+	}
+	return snapshot
+}
+
+// Refresh recomputes the snapshot, emits a CapacityTransition for every
+// dimension that flipped between has-free-share and exhausted since the
+// last call, and — if a writer was configured — upserts the per-device
+// status object.
+func (r *CapacityReporter) Refresh(ctx context.Context, deviceCapacity map[DeviceID]map[resourceapi.QualifiedName]resourceapi.DeviceCapacity, consumed ConsumedCapacityCollection) error {
+	snapshot := r.Snapshot(deviceCapacity, consumed)
+	for deviceID, deviceStatus := range snapshot {
+		for name, status := range deviceStatus {
+			// consumed only ever reflects committed allocations, never the
+			// allocatingCapacity a scheduler passes into CmpRequestOverCapacity
+			// for an in-flight decision, so these gauges never double-count
+			// a candidate that hasn't been committed yet.
+			currentMetrics.ObserveCapacity(deviceID, name, status.Total.AsApproximateFloat64(), status.Consumed.AsApproximateFloat64())
+			currentMetrics.SetOvercommitRatio(deviceID, name, consumed.OvercommitLevel(deviceID, name, status.Total))
+
+			wasExhausted := r.exhausted[deviceID][name]
+			isExhausted := status.Free.Sign() <= 0 && status.PolicyKind != ""
+			if wasExhausted != isExhausted {
+				if r.exhausted[deviceID] == nil {
+					r.exhausted[deviceID] = map[resourceapi.QualifiedName]bool{}
+				}
+				r.exhausted[deviceID][name] = isExhausted
+				select {
+				case r.transitions <- CapacityTransition{DeviceID: deviceID, Name: name, Exhausted: isExhausted}:
+				default:
+					// Channel full: drop rather than block the refresh loop.
+				}
+			}
+		}
+		if r.writer != nil {
+			if err := r.writer.Upsert(ctx, deviceID, deviceStatus); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func capacityStatusFor(cap resourceapi.DeviceCapacity, consumed *resource.Quantity) CapacityStatus {
+	status := CapacityStatus{Total: cap.Value}
+	if consumed != nil {
+		status.Consumed = consumed.DeepCopy()
+	}
+	status.Free = cap.Value.DeepCopy()
+	status.Free.Sub(status.Consumed)
+	if !isConsumableCapacity(cap) {
+		return status
+	}
+	switch {
+	case cap.SharingPolicy.ValidRange != nil:
+		status.PolicyKind = "ValidRange"
+	case cap.SharingPolicy.ValidValues != nil:
+		status.PolicyKind = "ValidValues"
+	}
+	return status
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"testing"
+
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	draapi "k8s.io/dynamic-resource-allocation/api"
+	"k8s.io/utils/ptr"
+)
+
+func TestCapacityReporterSnapshot(t *testing.T) {
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+	ten := resource.MustParse("10")
+	one := resource.MustParse("1")
+	deviceCapacity := map[DeviceID]map[resourceapi.QualifiedName]resourceapi.DeviceCapacity{
+		deviceID: {
+			"range-capacity": {
+				Value: ten,
+				SharingPolicy: &resourceapi.CapacitySharingPolicy{
+					Default:    ptr.To(one),
+					ValidRange: &resourceapi.CapacitySharingPolicyRange{Minimum: one},
+				},
+			},
+			"set-capacity": {
+				Value:         ten,
+				SharingPolicy: &resourceapi.CapacitySharingPolicy{Default: ptr.To(one), ValidValues: []resource.Quantity{one}},
+			},
+			"fixed-capacity": {
+				Value: ten,
+			},
+		},
+	}
+	consumed := ConsumedCapacityCollection{
+		deviceID: ConsumedCapacity{
+			"range-capacity": &ten,
+		},
+	}
+
+	r := NewCapacityReporter(nil)
+	snapshot := r.Snapshot(deviceCapacity, consumed)
+	status := snapshot[deviceID]
+
+	if got := status["range-capacity"]; got.PolicyKind != "ValidRange" || got.Free.Sign() != 0 {
+		t.Errorf("range-capacity: expected exhausted ValidRange status, got %+v", got)
+	}
+	if got := status["set-capacity"]; got.PolicyKind != "ValidValues" || got.Free.Cmp(ten) != 0 {
+		t.Errorf("set-capacity: expected untouched ValidValues status, got %+v", got)
+	}
+	if got := status["fixed-capacity"]; got.PolicyKind != "" {
+		t.Errorf("fixed-capacity: expected empty policy kind for non-consumable capacity, got %+v", got)
+	}
+}
+
+func TestCapacityReporterRefreshEmitsTransitions(t *testing.T) {
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+	ten := resource.MustParse("10")
+	one := resource.MustParse("1")
+	deviceCapacity := map[DeviceID]map[resourceapi.QualifiedName]resourceapi.DeviceCapacity{
+		deviceID: {
+			"range-capacity": {
+				Value: ten,
+				SharingPolicy: &resourceapi.CapacitySharingPolicy{
+					Default:    ptr.To(one),
+					ValidRange: &resourceapi.CapacitySharingPolicyRange{Minimum: one},
+				},
+			},
+		},
+	}
+
+	r := NewCapacityReporter(nil)
+	if err := r.Refresh(nil, deviceCapacity, ConsumedCapacityCollection{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-r.Transitions():
+		t.Fatal("did not expect a transition before the device was exhausted")
+	default:
+	}
+
+	consumed := ConsumedCapacityCollection{deviceID: ConsumedCapacity{"range-capacity": &ten}}
+	if err := r.Refresh(nil, deviceCapacity, consumed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case transition := <-r.Transitions():
+		if !transition.Exhausted {
+			t.Errorf("expected exhausted=true transition, got %+v", transition)
+		}
+	default:
+		t.Fatal("expected a transition after the device became exhausted")
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	draapi "k8s.io/dynamic-resource-allocation/api"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+func deviceWithVersion(version string) *draapi.BasicDevice {
+	return &draapi.BasicDevice{
+		Attributes: map[draapi.QualifiedName]draapi.DeviceAttribute{
+			"driver.example.com/version": {VersionValue: &version},
+		},
+	}
+}
+
+func TestVersionRangeConstraint(t *testing.T) {
+	testcases := map[string]struct {
+		expression string
+		version    string
+		wantMatch  bool
+	}{
+		"ge-lt-in-range":          {">=535.0.0, <536", "535.54.3", true},
+		"ge-lt-out-of-range":      {">=535.0.0, <536", "536.0.0", false},
+		"tilde-same-minor":        {"~1.2", "1.2.9", true},
+		"tilde-different-minor":   {"~1.2", "1.3.0", false},
+		"tilde-below-lower-bound": {"~1.2.5", "1.2.0", false},
+		"caret-same-major":        {"^1.2.0", "1.9.0", true},
+		"caret-different-major":   {"^1.2.0", "2.0.0", false},
+		"exact-match":             {"1.2.3", "1.2.3", true},
+		"exact-mismatch":          {"1.2.3", "1.2.4", false},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			c, err := newVersionRangeConstraint(klog.Background(), sets.New[string](), "driver.example.com/version", tc.expression)
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", tc.expression, err)
+			}
+			device := deviceWithVersion(tc.version)
+			match := c.add("req-0", device, DeviceID{})
+			if match != tc.wantMatch {
+				t.Errorf("expected match=%v for version %q in range %q, got %v", tc.wantMatch, tc.version, tc.expression, match)
+			}
+		})
+	}
+}
+
+func TestParseSemverRangeExpressionInvalid(t *testing.T) {
+	if _, err := parseSemverRangeExpression(""); err == nil {
+		t.Error("expected error for empty expression")
+	}
+	if _, err := parseSemverRangeExpression("not-a-version"); err == nil {
+		t.Error("expected error for invalid version")
+	}
+}
+
+func deviceWithStringAttribute(name draapi.FullyQualifiedName, value string) *draapi.BasicDevice {
+	return &draapi.BasicDevice{
+		Attributes: map[draapi.QualifiedName]draapi.DeviceAttribute{
+			draapi.QualifiedName(name): {StringValue: &value},
+		},
+	}
+}
+
+func TestGroupByAttributeConstraint(t *testing.T) {
+	const attrName draapi.FullyQualifiedName = "driver.example.com/numaNode"
+	c := &groupByAttributeConstraint{
+		logger:        klog.Background(),
+		requestNames:  sets.New[string](),
+		attributeName: attrName,
+		maxGroups:     2,
+		groups:        map[string]int{},
+	}
+
+	node0a := deviceWithStringAttribute(attrName, "node-0")
+	node0b := deviceWithStringAttribute(attrName, "node-0")
+	node1 := deviceWithStringAttribute(attrName, "node-1")
+	node2 := deviceWithStringAttribute(attrName, "node-2")
+
+	if !c.add("req-0", node0a, DeviceID{}) {
+		t.Fatal("expected first device to be admitted")
+	}
+	if !c.add("req-0", node0b, DeviceID{}) {
+		t.Fatal("expected second device in the same group to be admitted")
+	}
+	if !c.add("req-0", node1, DeviceID{}) {
+		t.Fatal("expected device in a second group to be admitted")
+	}
+	if c.add("req-0", node2, DeviceID{}) {
+		t.Fatal("expected device in a third group to be rejected, maxGroups=2")
+	}
+
+	// Removing one of the node-0 devices keeps the group alive because
+	// node0b is still part of it.
+	c.remove("req-0", node0a, DeviceID{})
+	if c.add("req-0", node2, DeviceID{}) {
+		t.Fatal("expected third group to still be rejected while node-0 and node-1 remain allocated")
+	}
+
+	// Draining node-0 entirely frees up a group slot for node-2.
+	c.remove("req-0", node0b, DeviceID{})
+	if !c.add("req-0", node2, DeviceID{}) {
+		t.Fatal("expected third group to be admitted once node-0 was fully removed")
+	}
+}
+
+func TestPreferredConstraint(t *testing.T) {
+	const attrName draapi.FullyQualifiedName = "driver.example.com/nvlinkDomain"
+	wrapped := &matchAttributeConstraint{
+		logger:        klog.Background(),
+		requestNames:  sets.New[string](),
+		attributeName: attrName,
+	}
+	p := newPreferredConstraint(klog.Background(), wrapped)
+
+	domainA0 := deviceWithStringAttribute(attrName, "domain-a")
+	domainA1 := deviceWithStringAttribute(attrName, "domain-a")
+	domainB := deviceWithStringAttribute(attrName, "domain-b")
+
+	deviceA0 := DeviceID{Device: draapi.MakeUniqueString("device-a0")}
+	deviceA1 := DeviceID{Device: draapi.MakeUniqueString("device-a1")}
+	deviceB := DeviceID{Device: draapi.MakeUniqueString("device-b")}
+
+	if !p.add("req-0", domainA0, deviceA0) {
+		t.Fatal("preferred constraint must never reject")
+	}
+	if !p.add("req-0", domainA1, deviceA1) {
+		t.Fatal("preferred constraint must never reject")
+	}
+	if p.Score() != 2 {
+		t.Fatalf("expected score 2 after two matching devices, got %d", p.Score())
+	}
+
+	// domain-b does not match domain-a, so the wrapped constraint would
+	// reject it, but the preferred wrapper still admits it.
+	if !p.add("req-0", domainB, deviceB) {
+		t.Fatal("preferred constraint must never reject, even on mismatch")
+	}
+	if p.Score() != 2 {
+		t.Fatalf("expected score to stay at 2 after a mismatched device, got %d", p.Score())
+	}
+
+	p.remove("req-0", domainB, deviceB)
+	if p.Score() != 2 {
+		t.Fatalf("expected score to remain 2 after removing the mismatched device, got %d", p.Score())
+	}
+}
+
+// TestPreferredConstraintZeroScoreFallback covers the case where no
+// candidate device ever satisfies the wrapped constraint: every add must
+// still admit the device (the soft tier never blocks allocation), but
+// Score() stays at zero so the allocator's ranking correctly treats this
+// as "the preferred attribute was never honored" rather than silently
+// reporting partial credit. matchAttributeConstraint always admits
+// whichever device arrives first, so versionRangeConstraint is used here
+// instead: its range is fixed up front and can reject even the first
+// candidate.
+func TestPreferredConstraintZeroScoreFallback(t *testing.T) {
+	wrapped, err := newVersionRangeConstraint(klog.Background(), sets.New[string](), "driver.example.com/version", ">=999.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error parsing version range: %v", err)
+	}
+	p := newPreferredConstraint(klog.Background(), wrapped)
+
+	tooOld0 := deviceWithVersion("535.54.3")
+	tooOld1 := deviceWithVersion("1.0.0")
+
+	if !p.add("req-0", tooOld0, DeviceID{Device: draapi.MakeUniqueString("device-0")}) {
+		t.Fatal("preferred constraint must never reject")
+	}
+	if !p.add("req-0", tooOld1, DeviceID{Device: draapi.MakeUniqueString("device-1")}) {
+		t.Fatal("preferred constraint must never reject")
+	}
+
+	if p.Score() != 0 {
+		t.Fatalf("expected score 0 when no device ever satisfied the wrapped range, got %d", p.Score())
+	}
+}
+
+// TestPreferredConstraintTies covers the allocator-facing scenario two
+// alternative allocations score identically: Score() is a plain count,
+// so two preferredConstraint instances fed an equal number of matching
+// devices must compare equal, leaving the tie-break to the allocator's
+// documented "keep today's first-found behavior" rule rather than this
+// type inventing one.
+func TestPreferredConstraintTies(t *testing.T) {
+	const attrName draapi.FullyQualifiedName = "driver.example.com/nvlinkDomain"
+	newAlternative := func() *preferredConstraint {
+		return newPreferredConstraint(klog.Background(), &matchAttributeConstraint{
+			logger:        klog.Background(),
+			requestNames:  sets.New[string](),
+			attributeName: attrName,
+		})
+	}
+	alt1 := newAlternative()
+	alt2 := newAlternative()
+
+	domain := deviceWithStringAttribute(attrName, "domain-a")
+	alt1.add("req-0", domain, DeviceID{Device: draapi.MakeUniqueString("alt1-device-0")})
+	alt1.add("req-0", domain, DeviceID{Device: draapi.MakeUniqueString("alt1-device-1")})
+	alt2.add("req-0", domain, DeviceID{Device: draapi.MakeUniqueString("alt2-device-0")})
+	alt2.add("req-0", domain, DeviceID{Device: draapi.MakeUniqueString("alt2-device-1")})
+
+	if alt1.Score() != alt2.Score() {
+		t.Fatalf("expected tied alternatives to score equally, got %d and %d", alt1.Score(), alt2.Score())
+	}
+}
+
+// TestRequiredAndPreferredConstraintOnSameAttribute covers a Required and
+// a Preferred constraint both watching the same attribute: the Required
+// one still gates admission as usual, and only devices it allows ever
+// reach the Preferred one, so the Preferred constraint's score reflects
+// devices that passed both, not an independent view of the attribute.
+func TestRequiredAndPreferredConstraintOnSameAttribute(t *testing.T) {
+	const attrName draapi.FullyQualifiedName = "driver.example.com/nvlinkDomain"
+	required := &matchAttributeConstraint{
+		logger:        klog.Background(),
+		requestNames:  sets.New[string](),
+		attributeName: attrName,
+	}
+	preferred := newPreferredConstraint(klog.Background(), &matchAttributeConstraint{
+		logger:        klog.Background(),
+		requestNames:  sets.New[string](),
+		attributeName: attrName,
+	})
+	constraints := []constraint{required, preferred}
+
+	domainA0 := deviceWithStringAttribute(attrName, "domain-a")
+	domainA1 := deviceWithStringAttribute(attrName, "domain-a")
+	domainB := deviceWithStringAttribute(attrName, "domain-b")
+
+	if !EvaluateConstraints(constraints, "req-0", domainA0, DeviceID{Device: draapi.MakeUniqueString("device-a0")}) {
+		t.Fatal("expected first device to be admitted and to fix domain-a as the required value")
+	}
+	if !EvaluateConstraints(constraints, "req-0", domainA1, DeviceID{Device: draapi.MakeUniqueString("device-a1")}) {
+		t.Fatal("expected a second domain-a device to satisfy both the required and preferred constraints")
+	}
+	if preferred.Score() != 2 {
+		t.Fatalf("expected preferred score 2 after two devices passed both constraints, got %d", preferred.Score())
+	}
+
+	// domain-b never reaches the preferred constraint: the required one
+	// rejects it outright, so EvaluateConstraints must report rejection
+	// rather than letting the preferred constraint's "never reject"
+	// behavior paper over it.
+	if EvaluateConstraints(constraints, "req-0", domainB, DeviceID{Device: draapi.MakeUniqueString("device-b")}) {
+		t.Fatal("expected the required constraint to reject a device outside domain-a")
+	}
+	if preferred.Score() != 2 {
+		t.Fatalf("expected preferred score to stay at 2 when the required constraint rejected first, got %d", preferred.Score())
+	}
+}
+
+// TestEvaluateConstraints exercises EvaluateConstraints as the shared
+// dispatch path a real allocator's per-device loop would use: a Required
+// groupByAttributeConstraint gates admission, while a preferredConstraint
+// wrapping a versionRangeConstraint never gates but still records whether
+// each admitted device satisfied it.
+func TestEvaluateConstraints(t *testing.T) {
+	const numaAttr draapi.FullyQualifiedName = "driver.example.com/numaNode"
+	const versionAttr draapi.FullyQualifiedName = "driver.example.com/version"
+
+	required := &groupByAttributeConstraint{
+		logger:        klog.Background(),
+		requestNames:  sets.New[string](),
+		attributeName: numaAttr,
+		maxGroups:     1,
+		groups:        map[string]int{},
+	}
+	versionRange, err := newVersionRangeConstraint(klog.Background(), sets.New[string](), versionAttr, ">=535.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error parsing version range: %v", err)
+	}
+	preferred := newPreferredConstraint(klog.Background(), versionRange)
+	constraints := []constraint{required, preferred}
+
+	node0New := draapi.BasicDevice{
+		Attributes: map[draapi.QualifiedName]draapi.DeviceAttribute{
+			draapi.QualifiedName(numaAttr):    {StringValue: ptr.To("node-0")},
+			draapi.QualifiedName(versionAttr): {VersionValue: ptr.To("535.54.3")},
+		},
+	}
+	deviceOK := DeviceID{Device: draapi.MakeUniqueString("device-ok")}
+	if !EvaluateConstraints(constraints, "req-0", &node0New, deviceOK) {
+		t.Fatal("expected device matching both constraints to be admitted")
+	}
+	if preferred.Score() != 1 {
+		t.Fatalf("expected preferred score 1 after a version match, got %d", preferred.Score())
+	}
+
+	// node-0 again, but with an old version: the Required constraint still
+	// admits it (same group), the Preferred constraint does not reject it
+	// either, but it leaves Score() unchanged.
+	node0Old := draapi.BasicDevice{
+		Attributes: map[draapi.QualifiedName]draapi.DeviceAttribute{
+			draapi.QualifiedName(numaAttr):    {StringValue: ptr.To("node-0")},
+			draapi.QualifiedName(versionAttr): {VersionValue: ptr.To("400.0.0")},
+		},
+	}
+	deviceOldVersion := DeviceID{Device: draapi.MakeUniqueString("device-old-version")}
+	if !EvaluateConstraints(constraints, "req-0", &node0Old, deviceOldVersion) {
+		t.Fatal("expected device with a stale version to still be admitted: the version check is only Preferred")
+	}
+	if preferred.Score() != 1 {
+		t.Fatalf("expected preferred score to stay at 1 after an unmatched-but-admitted device, got %d", preferred.Score())
+	}
+
+	// A second NUMA node is rejected by the Required constraint (maxGroups
+	// is 1). The Required constraint comes first, so evaluation stops
+	// before the Preferred constraint ever sees the device and its score
+	// is left as it was.
+	node1 := draapi.BasicDevice{
+		Attributes: map[draapi.QualifiedName]draapi.DeviceAttribute{
+			draapi.QualifiedName(numaAttr):    {StringValue: ptr.To("node-1")},
+			draapi.QualifiedName(versionAttr): {VersionValue: ptr.To("535.54.3")},
+		},
+	}
+	deviceRejected := DeviceID{Device: draapi.MakeUniqueString("device-rejected")}
+	if EvaluateConstraints(constraints, "req-0", &node1, deviceRejected) {
+		t.Fatal("expected device in a second NUMA node to be rejected, maxGroups=1")
+	}
+	if preferred.Score() != 1 {
+		t.Fatalf("expected preferred score to remain 1 after a rejected device rolled back, got %d", preferred.Score())
+	}
+}
@@ -17,11 +17,18 @@ limitations under the License.
 package structured
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/tracing"
 	draapi "k8s.io/dynamic-resource-allocation/api"
 )
 
@@ -29,6 +36,92 @@ type SharedDeviceIDList map[SharedDeviceID]struct{}
 
 type SharedDeviceID struct {
 	Driver, Pool, Device, ShareID draapi.UniqueString
+
+	// VendorClass is the CDI "vendor.com/class" prefix this device is
+	// registered under. It is only set when the share ID was generated in
+	// ShareIDFormatCDI, so that CDIDeviceName can build the exact string
+	// the container runtime expects without a second translation step.
+	VendorClass string
+}
+
+// ShareIDFormat selects how UniqueHexStringFactory renders generated
+// share IDs.
+type ShareIDFormat string
+
+const (
+	// ShareIDFormatHex is today's opaque hex string, e.g. "a1b2c3d4".
+	ShareIDFormatHex ShareIDFormat = "Hex"
+	// ShareIDFormatCDI renders share IDs as CDI-compliant device names of
+	// the form "vendor.com/class=deviceName-shareHex".
+	ShareIDFormatCDI ShareIDFormat = "CDI"
+	// ShareIDFormatDeterministic derives share IDs from a hash of the
+	// device and a caller-supplied index instead of random bytes, so that
+	// GenerateDeterministicShareID returns the same value across scheduler
+	// restarts for the same (device, index) pair.
+	ShareIDFormatDeterministic ShareIDFormat = "Deterministic"
+)
+
+// cdiNameComponent matches the CDI spec's allowed characters for a
+// device name component: lowercase alphanumerics, '-', '_', '.'.
+var cdiNameComponent = regexp.MustCompile(`^[a-z0-9_.-]+$`)
+
+// maxCDINameLength is the CDI spec's limit for the device name component
+// (the part after the '=').
+const maxCDINameLength = 63
+
+// CDIDeviceName returns the CDI-compliant device name for this share,
+// e.g. "vendor.com/class=my-gpu-a1b2c3d4". It only succeeds when the
+// share ID was generated with ShareIDFormatCDI, since that is what
+// guarantees VendorClass and the share hex were already validated
+// against the CDI naming rules.
+func (d SharedDeviceID) CDIDeviceName() (string, error) {
+	if d.VendorClass == "" {
+		return "", fmt.Errorf("share %s was not generated in CDI format: no vendor/class recorded", d.String())
+	}
+	name := cdiDeviceName(d.Device.String(), d.ShareID.String())
+	if err := validateCDIDeviceName(name); err != nil {
+		return "", err
+	}
+	return d.VendorClass + "=" + name, nil
+}
+
+// cdiDeviceName joins a device name and a share ID into the CDI device
+// name component (the part after the '='), e.g. "my-gpu-a1b2c3d4".
+func cdiDeviceName(device, shareID string) string {
+	return device + "-" + shareID
+}
+
+// validateCDIDeviceName checks a candidate CDI device name (the part
+// after the '=') against the CDI spec's naming rules: lowercase
+// alphanumerics, '-', '_', '.', with a length limit, and exactly one '='
+// in the fully-qualified name once the vendor/class prefix is added.
+func validateCDIDeviceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("CDI device name must not be empty")
+	}
+	if len(name) > maxCDINameLength {
+		return fmt.Errorf("CDI device name %q exceeds %d characters", name, maxCDINameLength)
+	}
+	if !cdiNameComponent.MatchString(name) {
+		return fmt.Errorf("CDI device name %q contains characters outside [a-z0-9_.-]", name)
+	}
+	return nil
+}
+
+// validateCDIVendorClass checks that a "vendor.com/class" prefix has
+// exactly one '/' and contains only characters CDI allows on each side.
+func validateCDIVendorClass(vendorClass string) error {
+	parts := strings.SplitN(vendorClass, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("CDI vendor/class %q must have the form \"vendor.com/class\"", vendorClass)
+	}
+	if !cdiNameComponent.MatchString(parts[0]) {
+		return fmt.Errorf("CDI vendor %q contains characters outside [a-z0-9_.-]", parts[0])
+	}
+	if !cdiNameComponent.MatchString(parts[1]) {
+		return fmt.Errorf("CDI class %q contains characters outside [a-z0-9_.-]", parts[1])
+	}
+	return nil
 }
 
 func (s SharedDeviceIDList) Clone() SharedDeviceIDList {
@@ -60,15 +153,64 @@ type UniqueHexStringFactory struct {
 	mu      sync.Mutex
 	usedIDs SharedDeviceIDList
 	nBytes  int
+
+	format      ShareIDFormat
+	vendorClass string
+
+	// poolSeed scopes deterministic share IDs to the pool this factory
+	// was constructed for, so two pools deriving from the same
+	// (device, index) pair never collide.
+	poolSeed string
 }
 
 func NewUniqueHexStringFactory(nBytes int) *UniqueHexStringFactory {
 	return &UniqueHexStringFactory{
 		usedIDs: make(SharedDeviceIDList, 0),
 		nBytes:  nBytes,
+		format:  ShareIDFormatHex,
+	}
+}
+
+// NewCDIUniqueHexStringFactory is like NewUniqueHexStringFactory, but
+// generated share IDs are rendered as CDI-compliant device names under
+// the given "vendor.com/class" prefix.
+func NewCDIUniqueHexStringFactory(nBytes int, vendorClass string) (*UniqueHexStringFactory, error) {
+	if err := validateCDIVendorClass(vendorClass); err != nil {
+		return nil, err
+	}
+	return &UniqueHexStringFactory{
+		usedIDs:     make(SharedDeviceIDList, 0),
+		nBytes:      nBytes,
+		format:      ShareIDFormatCDI,
+		vendorClass: vendorClass,
+	}, nil
+}
+
+// NewDeterministicUniqueHexStringFactory creates a factory whose share
+// IDs are derived from a hash of the device and a caller-supplied index
+// rather than random bytes. poolSeed should be stable across process
+// restarts (e.g. the pool name) so that GenerateDeterministicShareID
+// reproduces the same share IDs after a scheduler crash and retry,
+// instead of leaking orphaned entries under a new random ID.
+func NewDeterministicUniqueHexStringFactory(nBytes int, poolSeed string) *UniqueHexStringFactory {
+	return &UniqueHexStringFactory{
+		usedIDs:  make(SharedDeviceIDList, 0),
+		nBytes:   nBytes,
+		format:   ShareIDFormatDeterministic,
+		poolSeed: poolSeed,
 	}
 }
 
+// SetUsedShareIDs replaces the factory's used-ID set wholesale, for a
+// caller that has reconstructed it from some other source of truth.
+//
+// This checkout has no code that reads CDI spec files from disk to build
+// that set, so a restarted process cannot yet seed usedIDs from share
+// names the container runtime already has cached there; SetUsedShareIDs
+// only does what its caller gives it. That's the same gap
+// counterShareIDFactory.RestoreHighWaterMark discloses: nothing in this
+// checkout loads persisted share IDs at startup, so closing it is
+// follow-up work, not something this factory can do on its own.
 func (f *UniqueHexStringFactory) SetUsedShareIDs(usedIDs SharedDeviceIDList) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -83,8 +225,15 @@ func (f *UniqueHexStringFactory) SetUsedShareIDs(usedIDs SharedDeviceIDList) {
 //
 // The function attempts up to maxTry times to generate a unique ID. If a unique ID
 // is found, it is added to the usedIDs map and returned. If all attempts fail,
-// an error is returned.
-func (f *UniqueHexStringFactory) GenerateNewShareID(deviceID DeviceID, maxTry int) (string, error) {
+// an error is returned and, if a metrics hook is installed via SetMetrics, a
+// dra_shared_device_share_id_allocation_failures_total event is recorded so
+// operators can alert on collision storms. ctx is used to attach a tracing
+// span around the attempt; pass context.Background() if no caller span is
+// available.
+func (f *UniqueHexStringFactory) GenerateNewShareID(ctx context.Context, deviceID DeviceID, maxTry int) (string, error) {
+	_, span := tracing.Start(ctx, "UniqueHexStringFactory.GenerateNewShareID")
+	defer span.End()
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	count := 0
@@ -95,23 +244,154 @@ func (f *UniqueHexStringFactory) GenerateNewShareID(deviceID DeviceID, maxTry in
 			return "", fmt.Errorf("failed to generate random bytes: %w", err)
 		}
 		ShareID := hex.EncodeToString(b)
-		sharedDeviceID := MakeSharedDeviceID(deviceID, ShareID)
+		sharedDeviceID := f.makeSharedDeviceID(deviceID, ShareID)
+		if f.format == ShareIDFormatCDI {
+			if err := validateCDIDeviceName(cdiDeviceName(deviceID.Device.String(), ShareID)); err != nil {
+				return "", err
+			}
+		}
 		if _, exists := f.usedIDs[sharedDeviceID]; !exists {
 			f.usedIDs[sharedDeviceID] = struct{}{} // Mark UID as used
+			f.reportActiveShareIDsLocked(deviceID)
 			return ShareID, nil
 		}
 		count += 1
 		if count > maxTry {
+			currentMetrics.ShareIDExhausted(deviceID)
 			return "", fmt.Errorf("failed to find unique hex string within %d try", maxTry)
 		}
 	}
 }
 
+// reportActiveShareIDsLocked updates the active-share-ID gauge for
+// deviceID. Callers must hold f.mu.
+func (f *UniqueHexStringFactory) reportActiveShareIDsLocked(deviceID DeviceID) {
+	if currentMetrics == nil {
+		return
+	}
+	count := 0
+	for sharedDeviceID := range f.usedIDs {
+		if sharedDeviceID.Driver == deviceID.Driver && sharedDeviceID.Pool == deviceID.Pool && sharedDeviceID.Device == deviceID.Device {
+			count++
+		}
+	}
+	currentMetrics.SetActiveShareIDs(deviceID, count)
+}
+
+// GenerateDeterministicShareID derives a share ID from a hash of the
+// factory's poolSeed, deviceID, and index, and reserves it in usedIDs.
+// Calling it again with the same (deviceID, index) returns the same
+// share ID, so a scheduler that recomputes the same index after a crash
+// reconciles back to the identical share ID rather than generating a
+// new one and orphaning the old entry. It only succeeds on a factory
+// created with NewDeterministicUniqueHexStringFactory.
+func (f *UniqueHexStringFactory) GenerateDeterministicShareID(deviceID DeviceID, index uint64) (string, error) {
+	if f.format != ShareIDFormatDeterministic {
+		return "", fmt.Errorf("factory is not configured for deterministic share IDs (format=%s)", f.format)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := sha256.New()
+	h.Write([]byte(f.poolSeed))
+	h.Write([]byte(deviceID.Driver.String()))
+	h.Write([]byte(deviceID.Pool.String()))
+	h.Write([]byte(deviceID.Device.String()))
+	var indexBytes [8]byte
+	binary.BigEndian.PutUint64(indexBytes[:], index)
+	h.Write(indexBytes[:])
+	sum := h.Sum(nil)
+	ShareID := hex.EncodeToString(sum[:f.nBytes])
+
+	sharedDeviceID := f.makeSharedDeviceID(deviceID, ShareID)
+	f.usedIDs[sharedDeviceID] = struct{}{}
+	return ShareID, nil
+}
+
+// ErrShareIDConflict is returned by ReserveShareID when the requested
+// share ID is already in use for the device.
+type ErrShareIDConflict struct {
+	SharedDeviceID SharedDeviceID
+}
+
+func (e *ErrShareIDConflict) Error() string {
+	return fmt.Sprintf("share ID %q is already reserved for device %s", e.SharedDeviceID.ShareID.String(), e.SharedDeviceID.String())
+}
+
+// ReserveShareID records a caller-supplied share ID as used, for drivers
+// that already have a stable identifier of their own (a GPU MIG
+// partition UUID, a NIC VF handle) and cannot accept a randomly
+// generated one. It returns *ErrShareIDConflict if the share ID is
+// already reserved for this device.
+func (f *UniqueHexStringFactory) ReserveShareID(deviceID DeviceID, shareID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.format == ShareIDFormatCDI {
+		if err := validateCDIDeviceName(cdiDeviceName(deviceID.Device.String(), shareID)); err != nil {
+			return err
+		}
+	}
+	sharedDeviceID := f.makeSharedDeviceID(deviceID, shareID)
+	if _, exists := f.usedIDs[sharedDeviceID]; exists {
+		return &ErrShareIDConflict{SharedDeviceID: sharedDeviceID}
+	}
+	f.usedIDs[sharedDeviceID] = struct{}{}
+	f.reportActiveShareIDsLocked(deviceID)
+	return nil
+}
+
+// makeSharedDeviceID builds the SharedDeviceID that will be tracked in
+// usedIDs, tagging it with the factory's vendor/class when generating
+// CDI-format share IDs so that callers can read back a ready-to-use
+// CDIDeviceName without re-deriving the prefix.
+func (f *UniqueHexStringFactory) makeSharedDeviceID(deviceID DeviceID, shareID string) SharedDeviceID {
+	sharedDeviceID := MakeSharedDeviceID(deviceID, shareID)
+	if f.format == ShareIDFormatCDI {
+		sharedDeviceID.VendorClass = f.vendorClass
+	}
+	return sharedDeviceID
+}
+
+// DeleteShareID removes a single share ID that a claim no longer needs.
+//
+// Deprecated: use Release, which is the same operation under a name that
+// doesn't collide with the Prune/Release vocabulary below.
 func (f *UniqueHexStringFactory) DeleteShareID(deviceID DeviceID, ShareID string) {
+	f.Release(deviceID, ShareID)
+}
+
+// Release removes a single share ID from usedIDs, freeing it for reuse.
+// Call it when the claim that held the share is deleted.
+func (f *UniqueHexStringFactory) Release(deviceID DeviceID, ShareID string) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	sharedDeviceID := MakeSharedDeviceID(deviceID, ShareID)
-	if _, exists := f.usedIDs[sharedDeviceID]; !exists {
+	sharedDeviceID := f.makeSharedDeviceID(deviceID, ShareID)
+	delete(f.usedIDs, sharedDeviceID)
+	f.reportActiveShareIDsLocked(deviceID)
+}
+
+// Prune removes every share ID tracked for deviceID whose ShareID is not
+// in keep, and returns the number of entries removed. It is meant for
+// reconciliation: once the scheduler knows the full set of share IDs
+// still referenced by live claims for a device, Prune clears out
+// anything left behind by claims that were deleted without a matching
+// Release call.
+func (f *UniqueHexStringFactory) Prune(deviceID DeviceID, keep sets.Set[string]) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pruned := 0
+	for sharedDeviceID := range f.usedIDs {
+		if sharedDeviceID.Driver != deviceID.Driver || sharedDeviceID.Pool != deviceID.Pool || sharedDeviceID.Device != deviceID.Device {
+			continue
+		}
+		if keep.Has(sharedDeviceID.ShareID.String()) {
+			continue
+		}
 		delete(f.usedIDs, sharedDeviceID)
+		pruned++
+	}
+	if pruned > 0 {
+		f.reportActiveShareIDsLocked(deviceID)
 	}
+	return pruned
 }
@@ -0,0 +1,215 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	compbasemetrics "k8s.io/component-base/metrics"
+	draapi "k8s.io/dynamic-resource-allocation/api"
+)
+
+func TestCDIUniqueHexStringFactory(t *testing.T) {
+	f, err := NewCDIUniqueHexStringFactory(4, "nvidia.com/gpu")
+	if err != nil {
+		t.Fatalf("unexpected error creating factory: %v", err)
+	}
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+
+	shareID, err := f.GenerateNewShareID(context.Background(), deviceID, 10)
+	if err != nil {
+		t.Fatalf("unexpected error generating share ID: %v", err)
+	}
+
+	sharedDeviceID := f.makeSharedDeviceID(deviceID, shareID)
+	cdiName, err := sharedDeviceID.CDIDeviceName()
+	if err != nil {
+		t.Fatalf("unexpected error building CDI device name: %v", err)
+	}
+	wantPrefix := "nvidia.com/gpu=gpu-0-"
+	if len(cdiName) <= len(wantPrefix) || cdiName[:len(wantPrefix)] != wantPrefix {
+		t.Errorf("expected CDI name to start with %q, got %q", wantPrefix, cdiName)
+	}
+}
+
+func TestCDIUniqueHexStringFactoryInvalidVendorClass(t *testing.T) {
+	if _, err := NewCDIUniqueHexStringFactory(4, "no-slash-here"); err == nil {
+		t.Error("expected error for vendor/class missing a slash")
+	}
+}
+
+func TestSharedDeviceIDCDIDeviceNameRequiresCDIFormat(t *testing.T) {
+	f := NewUniqueHexStringFactory(4)
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+	shareID, err := f.GenerateNewShareID(context.Background(), deviceID, 10)
+	if err != nil {
+		t.Fatalf("unexpected error generating share ID: %v", err)
+	}
+	sharedDeviceID := MakeSharedDeviceID(deviceID, shareID)
+	if _, err := sharedDeviceID.CDIDeviceName(); err == nil {
+		t.Error("expected error, share ID was not generated in CDI format")
+	}
+}
+
+func TestReserveShareIDRoundTrip(t *testing.T) {
+	f := NewUniqueHexStringFactory(4)
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+
+	if err := f.ReserveShareID(deviceID, "mig-3g.40gb-0"); err != nil {
+		t.Fatalf("unexpected error reserving driver-supplied share ID: %v", err)
+	}
+
+	var conflict *ErrShareIDConflict
+	if err := f.ReserveShareID(deviceID, "mig-3g.40gb-0"); !errors.As(err, &conflict) {
+		t.Fatalf("expected *ErrShareIDConflict reserving an already-used share ID, got %v", err)
+	}
+
+	f.Release(deviceID, "mig-3g.40gb-0")
+	if err := f.ReserveShareID(deviceID, "mig-3g.40gb-0"); err != nil {
+		t.Fatalf("expected share ID to be reservable again after Release, got %v", err)
+	}
+}
+
+func TestDeleteShareIDActuallyDeletes(t *testing.T) {
+	f := NewUniqueHexStringFactory(4)
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+
+	shareID, err := f.GenerateNewShareID(context.Background(), deviceID, 10)
+	if err != nil {
+		t.Fatalf("unexpected error generating share ID: %v", err)
+	}
+	f.DeleteShareID(deviceID, shareID)
+	if err := f.ReserveShareID(deviceID, shareID); err != nil {
+		t.Fatalf("expected share ID to be free after DeleteShareID, got %v", err)
+	}
+}
+
+func TestGenerateDeterministicShareIDIsStable(t *testing.T) {
+	f := NewDeterministicUniqueHexStringFactory(4, "pool-1")
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+
+	first, err := f.GenerateDeterministicShareID(deviceID, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Simulate a scheduler crash and retry: a fresh factory re-deriving
+	// the share ID for the same (deviceID, index) must reach the same
+	// value so the driver sees a consistent identifier.
+	restarted := NewDeterministicUniqueHexStringFactory(4, "pool-1")
+	second, err := restarted.GenerateDeterministicShareID(deviceID, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != second {
+		t.Errorf("expected deterministic share ID to be stable across factories, got %q and %q", first, second)
+	}
+
+	other, err := f.GenerateDeterministicShareID(deviceID, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == first {
+		t.Error("expected a different index to produce a different share ID")
+	}
+}
+
+func TestPruneRemovesStaleShareIDs(t *testing.T) {
+	f := NewUniqueHexStringFactory(4)
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+	other := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-1"),
+	}
+
+	if err := f.ReserveShareID(deviceID, "stale"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.ReserveShareID(deviceID, "live"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.ReserveShareID(other, "unrelated"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pruned := f.Prune(deviceID, sets.New("live"))
+	if pruned != 1 {
+		t.Fatalf("expected to prune exactly 1 stale share ID, pruned %d", pruned)
+	}
+	if err := f.ReserveShareID(deviceID, "stale"); err != nil {
+		t.Errorf("expected pruned share ID to be reservable again, got %v", err)
+	}
+	if err := f.ReserveShareID(other, "unrelated"); err == nil {
+		t.Error("expected the other device's share ID to be left untouched by Prune")
+	}
+}
+
+func TestGenerateNewShareIDRecordsExhaustionMetric(t *testing.T) {
+	registry := compbasemetrics.NewKubeRegistry()
+	SetMetrics(NewMetrics(registry))
+	defer SetMetrics(nil)
+
+	// nBytes=0 means every generated candidate hex-encodes to the empty
+	// string. Reserving that one possible value up front guarantees every
+	// subsequent attempt collides, so maxTry=0 exhausts immediately.
+	f := NewUniqueHexStringFactory(0)
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+	if err := f.ReserveShareID(deviceID, ""); err != nil {
+		t.Fatalf("unexpected error reserving the only possible hex string: %v", err)
+	}
+	if _, err := f.GenerateNewShareID(context.Background(), deviceID, 0); err == nil {
+		t.Fatal("expected exhaustion error with maxTry=0 and a single possible hex string")
+	}
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error gathering metrics: %v", err)
+	}
+	for _, family := range metricFamilies {
+		if family.GetName() == "dra_shared_device_share_id_allocation_failures_total" {
+			if len(family.GetMetric()) != 1 || family.GetMetric()[0].GetCounter().GetValue() != 1 {
+				t.Errorf("expected exactly one exhaustion event recorded, got %v", family)
+			}
+			return
+		}
+	}
+	t.Fatal("expected dra_shared_device_share_id_allocation_failures_total to be gathered")
+}
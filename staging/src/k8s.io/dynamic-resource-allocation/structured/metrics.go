@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	resourceapi "k8s.io/api/resource/v1beta1"
+	compbasemetrics "k8s.io/component-base/metrics"
+)
+
+const metricsSubsystem = "dra_shared_device"
+
+// Metrics holds the optional dra_shared_device_* Prometheus instrumentation
+// for shared-device capacity accounting: how much of a device's capacity is
+// used, how many share IDs are alive, and how often allocation attempts
+// fail. A nil *Metrics is valid and every method on it is then a no-op, so
+// callers that don't need the observability can skip NewMetrics entirely.
+type Metrics struct {
+	totalCapacity      *compbasemetrics.GaugeVec
+	consumedCapacity   *compbasemetrics.GaugeVec
+	overcommitRatio    *compbasemetrics.GaugeVec
+	activeShareIDs     *compbasemetrics.GaugeVec
+	shareIDExhausted   *compbasemetrics.CounterVec
+	policyViolations   *compbasemetrics.CounterVec
+	requestFitAttempts *compbasemetrics.HistogramVec
+}
+
+// NewMetrics creates the dra_shared_device_* metrics and registers them with
+// registerer.
+func NewMetrics(registerer compbasemetrics.KubeRegistry) *Metrics {
+	deviceLabels := []string{"driver", "pool", "device"}
+	capacityLabels := []string{"driver", "pool", "device", "capacity"}
+
+	m := &Metrics{
+		totalCapacity: compbasemetrics.NewGaugeVec(&compbasemetrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "total_capacity",
+			Help:           "Total capacity of a shared device, by driver, pool, device and capacity name.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, capacityLabels),
+		consumedCapacity: compbasemetrics.NewGaugeVec(&compbasemetrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "consumed_capacity",
+			Help:           "Consumed capacity of a shared device, by driver, pool, device and capacity name.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, capacityLabels),
+		activeShareIDs: compbasemetrics.NewGaugeVec(&compbasemetrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "active_share_ids",
+			Help:           "Number of share IDs currently allocated for a shared device, by driver, pool and device.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, deviceLabels),
+		shareIDExhausted: compbasemetrics.NewCounterVec(&compbasemetrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "share_id_allocation_failures_total",
+			Help:           "Number of times GenerateNewShareID exhausted its retry budget without finding a free share ID, by driver, pool and device.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, deviceLabels),
+		policyViolations: compbasemetrics.NewCounterVec(&compbasemetrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "policy_violations_total",
+			Help:           "Number of capacity requests rejected for violating a device's capacity sharing policy or exceeding its total capacity, by driver, pool, device and capacity name.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, capacityLabels),
+		overcommitRatio: compbasemetrics.NewGaugeVec(&compbasemetrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "overcommit_ratio",
+			Help:           "Consumed capacity as a fraction of total capacity for a shared device (1.0 = exactly full, >1.0 = overcommitted), by driver, pool, device and capacity name.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, capacityLabels),
+		requestFitAttempts: compbasemetrics.NewHistogramVec(&compbasemetrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "request_fit_attempts",
+			Help:           "Number of candidate devices a ConsumedCapacitySnapshot tried via TryInsert before one fit and was committed.",
+			Buckets:        compbasemetrics.LinearBuckets(1, 1, 10),
+			StabilityLevel: compbasemetrics.ALPHA,
+		}, nil),
+	}
+	registerer.MustRegister(m.totalCapacity, m.consumedCapacity, m.overcommitRatio, m.activeShareIDs, m.shareIDExhausted, m.policyViolations, m.requestFitAttempts)
+	return m
+}
+
+// ObserveCapacity records the total and consumed values of a single
+// capacity dimension on deviceID.
+func (m *Metrics) ObserveCapacity(deviceID DeviceID, capacityName resourceapi.QualifiedName, total, consumed float64) {
+	if m == nil {
+		return
+	}
+	labels := []string{deviceID.Driver.String(), deviceID.Pool.String(), deviceID.Device.String(), string(capacityName)}
+	m.totalCapacity.WithLabelValues(labels...).Set(total)
+	m.consumedCapacity.WithLabelValues(labels...).Set(consumed)
+}
+
+// SetActiveShareIDs records how many share IDs are currently allocated for
+// deviceID.
+func (m *Metrics) SetActiveShareIDs(deviceID DeviceID, count int) {
+	if m == nil {
+		return
+	}
+	m.activeShareIDs.WithLabelValues(deviceID.Driver.String(), deviceID.Pool.String(), deviceID.Device.String()).Set(float64(count))
+}
+
+// ShareIDExhausted records that GenerateNewShareID failed to find a free
+// share ID for deviceID within its retry budget.
+func (m *Metrics) ShareIDExhausted(deviceID DeviceID) {
+	if m == nil {
+		return
+	}
+	m.shareIDExhausted.WithLabelValues(deviceID.Driver.String(), deviceID.Pool.String(), deviceID.Device.String()).Inc()
+}
+
+// PolicyViolation records that a capacity request for capacityName on
+// deviceID was rejected, either for violating the device's capacity sharing
+// policy or for exceeding the dimension's total capacity.
+func (m *Metrics) PolicyViolation(deviceID DeviceID, capacityName resourceapi.QualifiedName) {
+	if m == nil {
+		return
+	}
+	m.policyViolations.WithLabelValues(deviceID.Driver.String(), deviceID.Pool.String(), deviceID.Device.String(), string(capacityName)).Inc()
+}
+
+// SetOvercommitRatio records consumed/total for a single capacity dimension
+// on deviceID, e.g. 1.5 meaning the device is committed to 150% of its
+// declared capacity. Callers typically derive
+// ratio via ConsumedCapacityCollection.OvercommitLevel.
+func (m *Metrics) SetOvercommitRatio(deviceID DeviceID, capacityName resourceapi.QualifiedName, ratio float64) {
+	if m == nil {
+		return
+	}
+	m.overcommitRatio.WithLabelValues(deviceID.Driver.String(), deviceID.Pool.String(), deviceID.Device.String(), string(capacityName)).Set(ratio)
+}
+
+// ObserveRequestFitAttempts records how many candidate devices a
+// ConsumedCapacitySnapshot examined via TryInsert before one fit and was
+// committed, so operators can see fragmentation building up before it
+// turns into unschedulable pods.
+func (m *Metrics) ObserveRequestFitAttempts(attempts int) {
+	if m == nil {
+		return
+	}
+	m.requestFitAttempts.WithLabelValues().Observe(float64(attempts))
+}
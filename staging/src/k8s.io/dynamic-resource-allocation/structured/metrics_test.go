@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"testing"
+
+	compbasemetrics "k8s.io/component-base/metrics"
+	draapi "k8s.io/dynamic-resource-allocation/api"
+)
+
+func TestNilMetricsAreNoOps(t *testing.T) {
+	var m *Metrics
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+
+	// None of these must panic: a nil *Metrics is the "no hook installed"
+	// state and every method on it should be a no-op.
+	m.ObserveCapacity(deviceID, "memory", 10, 1)
+	m.SetActiveShareIDs(deviceID, 1)
+	m.ShareIDExhausted(deviceID)
+	m.PolicyViolation(deviceID, "memory")
+	m.SetOvercommitRatio(deviceID, "memory", 1.5)
+	m.ObserveRequestFitAttempts(3)
+}
+
+func TestNewMetricsRegistersAllCollectors(t *testing.T) {
+	registry := compbasemetrics.NewKubeRegistry()
+	m := NewMetrics(registry)
+
+	deviceID := DeviceID{
+		Driver: draapi.MakeUniqueString("driver-a"),
+		Pool:   draapi.MakeUniqueString("pool-1"),
+		Device: draapi.MakeUniqueString("gpu-0"),
+	}
+	m.ObserveCapacity(deviceID, "memory", 10, 4)
+	m.SetActiveShareIDs(deviceID, 2)
+	m.ShareIDExhausted(deviceID)
+	m.PolicyViolation(deviceID, "memory")
+	m.SetOvercommitRatio(deviceID, "memory", 1.2)
+	m.ObserveRequestFitAttempts(3)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error gathering metrics: %v", err)
+	}
+	found := map[string]bool{}
+	for _, family := range metricFamilies {
+		found[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"dra_shared_device_total_capacity",
+		"dra_shared_device_consumed_capacity",
+		"dra_shared_device_overcommit_ratio",
+		"dra_shared_device_active_share_ids",
+		"dra_shared_device_share_id_allocation_failures_total",
+		"dra_shared_device_policy_violations_total",
+		"dra_shared_device_request_fit_attempts",
+	} {
+		if !found[name] {
+			t.Errorf("expected metric %q to be registered and gathered, got %v", name, found)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ShareIDFactory generates share IDs for a shared device. The counter and
+// UUIDv7 factories below satisfy it, so callers can swap the generation
+// strategy without changing how allocation results are threaded through.
+// UniqueHexStringFactory does not implement it: its GenerateNewShareID,
+// ReserveShareID and Release methods take different arguments.
+type ShareIDFactory interface {
+	Generate(deviceID DeviceID) (string, error)
+	Reserve(sharedDeviceID SharedDeviceID) error
+	Release(sharedDeviceID SharedDeviceID)
+
+	// RestoreHighWaterMark rebuilds whatever startup state a factory needs
+	// to stay collision-free after a process restart, from the count of
+	// share IDs already observed as used for deviceID. Implementations
+	// that don't need this (e.g. uuidv7ShareIDFactory) make it a no-op.
+	RestoreHighWaterMark(deviceID DeviceID, usedCounters uint64)
+}
+
+// shareIDEncoding renders bytes as lowercase base32 without padding, so
+// generated IDs stay safe for use in CDI and DNS-label contexts.
+var shareIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// counterShareIDFactory derives share IDs from a monotonic per-DeviceID
+// counter combined with a process-boot nonce. Unlike
+// UniqueHexStringFactory, it never has to scan a shared map or retry on
+// collision: the (nonce, device, counter) tuple is unique by
+// construction, which scales better under high churn.
+type counterShareIDFactory struct {
+	mu       sync.Mutex
+	nonce    uint32
+	counters map[DeviceID]uint64
+}
+
+// NewCounterShareIDFactory creates a ShareIDFactory backed by a
+// per-DeviceID counter and a random boot nonce.
+func NewCounterShareIDFactory() (ShareIDFactory, error) {
+	var nonceBytes [4]byte
+	if _, err := rand.Read(nonceBytes[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate boot nonce: %w", err)
+	}
+	return &counterShareIDFactory{
+		nonce:    binary.BigEndian.Uint32(nonceBytes[:]),
+		counters: map[DeviceID]uint64{},
+	}, nil
+}
+
+func (f *counterShareIDFactory) Generate(deviceID DeviceID) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counters[deviceID]++
+	return f.encode(f.counters[deviceID]), nil
+}
+
+func (f *counterShareIDFactory) encode(counter uint64) string {
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[0:4], f.nonce)
+	binary.BigEndian.PutUint64(buf[4:12], counter)
+	return shareIDEncoding.EncodeToString(buf[:])
+}
+
+func (f *counterShareIDFactory) Reserve(sharedDeviceID SharedDeviceID) error {
+	// Reservation only matters for restoring the high-water mark;
+	// counterShareIDFactory never collides, so there is nothing to check.
+	return nil
+}
+
+func (f *counterShareIDFactory) Release(sharedDeviceID SharedDeviceID) {
+	// The counter never needs to be decremented: skipping a few values
+	// after a release is harmless and keeps Generate collision-free even
+	// if an earlier released ID is still referenced elsewhere briefly.
+}
+
+// RestoreHighWaterMark rebuilds the per-DeviceID counter so that it is
+// always ahead of any persisted share ID, letting a restarted process
+// keep generating fresh IDs without colliding with ones the driver or
+// runtime already cached (e.g. from CDI spec files left on disk).
+//
+// This is counterShareIDFactory's analogue of the startup-restore job
+// UniqueHexStringFactory does via SetUsedShareIDs and the deterministic
+// mode chunk2-1 added (GenerateDeterministicShareID/ReserveShareID): all
+// three exist because a counter, a used-ID set, and a hash-seeded index
+// need different state to resume safely after a crash, not because this
+// is a second attempt at the same mechanism. No caller in this checkout
+// loads persisted share IDs at startup to invoke any of the three, so
+// all remain reachable only from their own unit tests until that
+// persistence-loading code exists.
+func (f *counterShareIDFactory) RestoreHighWaterMark(deviceID DeviceID, usedCounters uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if usedCounters > f.counters[deviceID] {
+		f.counters[deviceID] = usedCounters
+	}
+}
+
+// uuidv7ShareIDFactory generates time-ordered UUIDv7 share IDs, trading
+// the counter factory's compactness for IDs that sort by creation time,
+// which is useful when operators need to eyeball share IDs in logs or
+// `kubectl` output and tell which one is newest.
+type uuidv7ShareIDFactory struct{}
+
+// NewUUIDv7ShareIDFactory creates a ShareIDFactory that generates
+// RFC 9562 UUIDv7 share IDs.
+func NewUUIDv7ShareIDFactory() ShareIDFactory {
+	return uuidv7ShareIDFactory{}
+}
+
+func (uuidv7ShareIDFactory) Generate(deviceID DeviceID) (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	ms := uint64(time.Now().UnixMilli())
+	uuid[0] = byte(ms >> 40)
+	uuid[1] = byte(ms >> 32)
+	uuid[2] = byte(ms >> 24)
+	uuid[3] = byte(ms >> 16)
+	uuid[4] = byte(ms >> 8)
+	uuid[5] = byte(ms)
+
+	// Version 7 and RFC 9562 variant bits.
+	uuid[6] = (uuid[6] & 0x0f) | 0x70
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
+
+func (uuidv7ShareIDFactory) Reserve(sharedDeviceID SharedDeviceID) error {
+	// UUIDv7 collisions are astronomically unlikely; nothing to reserve.
+	return nil
+}
+
+func (uuidv7ShareIDFactory) Release(sharedDeviceID SharedDeviceID) {}
+
+func (uuidv7ShareIDFactory) RestoreHighWaterMark(deviceID DeviceID, usedCounters uint64) {
+	// UUIDv7 carries no counter state to restore.
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structured
+
+import (
+	"testing"
+
+	draapi "k8s.io/dynamic-resource-allocation/api"
+)
+
+func TestCounterShareIDFactoryIsCollisionFree(t *testing.T) {
+	f, err := NewCounterShareIDFactory()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := f.Generate(deviceID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if seen[id] {
+			t.Fatalf("generated duplicate share ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestCounterShareIDFactoryRestoresHighWaterMark(t *testing.T) {
+	f, err := NewCounterShareIDFactory()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := f.(*counterShareIDFactory)
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+
+	c.RestoreHighWaterMark(deviceID, 41)
+	id, err := f.Generate(deviceID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := id, c.encode(42); got != want {
+		t.Errorf("expected first generated ID after restoring high-water mark 41 to encode counter 42 (%q), got %q", want, got)
+	}
+}
+
+func TestUUIDv7ShareIDFactoryIsTimeOrdered(t *testing.T) {
+	f := NewUUIDv7ShareIDFactory()
+	deviceID := DeviceID{Device: draapi.MakeUniqueString("gpu-0")}
+
+	first, err := f.Generate(deviceID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := f.Generate(deviceID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Fatal("expected two distinct UUIDs")
+	}
+	if len(first) != 36 || len(second) != 36 {
+		t.Errorf("expected canonical 36-character UUID strings, got %q and %q", first, second)
+	}
+	// The "xxxxxxxx-xxxx" prefix holds the 48-bit Unix millisecond
+	// timestamp, so the later ID must not sort before the earlier one there.
+	if len(first) == 36 && len(second) == 36 && second[:13] < first[:13] {
+		t.Errorf("expected timestamp prefix of %q not to sort before that of %q", second, first)
+	}
+}